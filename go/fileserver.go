@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/emicklei/go-restful"
+)
+
+// Options configures CrateFileServer.
+type Options struct {
+	// DisableDirectoryListing returns 403 instead of an autoindex for
+	// requests that resolve to a directory, since listing a crate's
+	// directory would leak yanked filenames to anyone who asks.
+	DisableDirectoryListing bool
+	// AllowedExtensions restricts which file extensions may be served.
+	// Empty means "use the default" (.crate, .json).
+	AllowedExtensions []string
+	// NotFound, when set, is consulted instead of a plain 404 when a file
+	// can't be opened under root. It's used to fall back to upstream for
+	// crate downloads missing from the local cache.
+	NotFound func(w http.ResponseWriter, r *http.Request, cleanPath string)
+	// Rewrite, when set, maps the requested subpath (e.g. Cargo's
+	// "{name}/{version}/download") to the path it's actually stored under
+	// (e.g. "{name}/{name}-{version}.crate") before it's opened.
+	Rewrite func(subpath string) string
+	// PathTraversalGuard rejects any subpath that resolves outside root
+	// after filepath.Clean, closing the path.Join(rootdir, userInput)
+	// traversal hole. Callers serving anything under a writable root should
+	// set this; it's opt-in rather than hardcoded on so a caller that
+	// already guarantees a safe subpath (e.g. one sourced entirely from its
+	// own index, never the request) isn't forced to pay for a redundant
+	// check.
+	PathTraversalGuard bool
+}
+
+var defaultAllowedExtensions = []string{".crate", ".json"}
+
+// CrateFileServer mounts a "GET {urlPrefix}/*subpath" route on ws that
+// serves files out of root, modeled after chi's FileServer helper. It
+// panics if urlPrefix contains a routing wildcard, redirects a bare prefix
+// (no trailing slash) to one with a trailing slash, optionally guards
+// against path traversal (see Options.PathTraversalGuard), and serves
+// through http.ServeContent so HTTP Range requests work for resumable crate
+// downloads.
+func CrateFileServer(ws *restful.WebService, urlPrefix string, root http.FileSystem, opts Options) {
+	if strings.ContainsAny(urlPrefix, "{}*") {
+		panic("CrateFileServer: urlPrefix must not contain URL parameters")
+	}
+
+	allowed := opts.AllowedExtensions
+	if len(allowed) == 0 {
+		allowed = defaultAllowedExtensions
+	}
+
+	prefix := strings.TrimSuffix(urlPrefix, "/")
+	handler := func(req *restful.Request, resp *restful.Response) {
+		if !strings.HasSuffix(req.Request.URL.Path, "/") && req.Request.URL.Path == prefix {
+			http.Redirect(resp.ResponseWriter, req.Request, req.Request.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+
+		subpath := req.PathParameter("subpath")
+		if opts.PathTraversalGuard && strings.Contains(subpath, "..") {
+			resp.WriteErrorString(http.StatusForbidden, "invalid path")
+			return
+		}
+		if opts.Rewrite != nil {
+			subpath = opts.Rewrite(subpath)
+		}
+		// filepath.Clean collapses any ".." or repeated slashes; comparing
+		// against the raw subpath (above, pre-Rewrite) catches an attempt to
+		// escape root instead of silently serving whatever it resolved to.
+		clean := filepath.Clean("/" + subpath)[1:]
+
+		if ext := filepath.Ext(clean); ext != "" && !containsString(allowed, ext) {
+			resp.WriteErrorString(http.StatusForbidden, "extension not allowed")
+			return
+		}
+
+		f, err := root.Open(clean)
+		if err != nil {
+			if opts.NotFound != nil {
+				opts.NotFound(resp.ResponseWriter, req.Request, clean)
+				return
+			}
+			resp.WriteErrorString(http.StatusNotFound, "not found")
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if info.IsDir() {
+			if opts.DisableDirectoryListing {
+				resp.WriteErrorString(http.StatusForbidden, "directory listing disabled")
+				return
+			}
+			http.StripPrefix(prefix, http.FileServer(root)).ServeHTTP(resp.ResponseWriter, req.Request)
+			return
+		}
+
+		http.ServeContent(resp.ResponseWriter, req.Request, info.Name(), info.ModTime(), f)
+	}
+
+	ws.Route(ws.GET(prefix + "/{subpath:*}").To(handler))
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,48 +1,179 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/emicklei/go-restful"
+
+	"github.com/m0ssc0de/cargo-fetcher/sparse"
 )
 
-// This example shows how to define methods that serve static files
-// It uses the standard http.ServeFile method
-//
 // GET http://localhost:8089/static/test.xml
 // GET http://localhost:8089/static/
-//
-// GET http://localhost:8089/static?resource=subdir/test.xml
 
 var rootdir = "/tmp/fetcher"
+var indexRoot = "/tmp/fetcher/index"
+
+var index = sparse.NewIndexStore(indexRoot)
 
 func main() {
+	flag.Parse()
+
 	restful.DefaultContainer.Router(restful.CurlyRouter{})
 
 	ws := new(restful.WebService)
-	ws.Route(ws.GET("/api/v1/crates/{subpath:*}").To(staticFromPathParam))
-	ws.Route(ws.GET("/static").To(staticFromQueryParam))
+	CrateFileServer(ws, "/api/v1/crates", http.Dir(rootdir), Options{
+		DisableDirectoryListing: true,
+		PathTraversalGuard:      true,
+		Rewrite:                 crateFilePath,
+		NotFound:                serveMissingCrate,
+	})
+	CrateFileServer(ws, "/static", http.Dir(rootdir), Options{
+		AllowedExtensions:  []string{".xml", ".json", ".crate"},
+		PathTraversalGuard: true,
+	})
+	ws.Route(ws.GET("/config.json").To(configJSON))
+	ws.Route(ws.GET("/1/{name}").To(indexFor1or2))
+	ws.Route(ws.GET("/2/{name}").To(indexFor1or2))
+	ws.Route(ws.GET("/3/{a}/{name}").To(indexFor3))
+	ws.Route(ws.GET("/{a}/{b}/{name}").To(indexFor4plus))
+	ws.Route(ws.PUT("/api/v1/crates/new").To(publishCrate))
+	ws.Route(ws.DELETE("/api/v1/crates/{name}/{version}/yank").To(yankCrate))
+	ws.Route(ws.PUT("/api/v1/crates/{name}/{version}/unyank").To(unyankCrate))
 	restful.Add(ws)
 
+	installFilters(restful.DefaultContainer, DefaultConfig())
+
 	println("[go-restful] serving files on http://localhost:8089/api/v1/crates from local /tmp/fetcher")
 	log.Fatal(http.ListenAndServe(":8089", nil))
 }
 
-func staticFromPathParam(req *restful.Request, resp *restful.Response) {
-	actual := path.Join(rootdir, req.PathParameter("subpath"))
-	fmt.Printf("serving %s ... (from %s)\n", actual, req.PathParameter("subpath"))
-	http.ServeFile(
-		resp.ResponseWriter,
-		req.Request,
-		actual)
+// crateFilePath maps a download subpath of the form "{name}/{version}/download"
+// (as advertised by the "dl" field in config.json) to the on-disk crate file
+// "{name}/{name}-{version}.crate". Any subpath that doesn't match that shape
+// is used as-is, preserving the original pass-through behavior.
+func crateFilePath(subpath string) string {
+	parts := strings.Split(strings.Trim(subpath, "/"), "/")
+	if len(parts) == 3 && parts[2] == "download" {
+		name, version := parts[0], parts[1]
+		return path.Join(name, fmt.Sprintf("%s-%s.crate", name, version))
+	}
+	return subpath
+}
+
+// serveMissingCrate is consulted by CrateFileServer when a requested crate
+// file isn't in rootdir. It falls back to the configured upstream, using the
+// index checksum (if the crate is known) to verify the download.
+func serveMissingCrate(w http.ResponseWriter, r *http.Request, cleanPath string) {
+	actual := path.Join(rootdir, cleanPath)
+	subpath := strings.TrimPrefix(r.URL.Path, "/api/v1/crates/")
+	parts := strings.Split(strings.Trim(subpath, "/"), "/")
+	if len(parts) != 3 || parts[2] != "download" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	name, version := parts[0], parts[1]
+
+	var wantCksum string
+	if versions, err := index.Read(strings.ToLower(name)); err == nil {
+		for _, v := range versions {
+			if v.Vers == version {
+				wantCksum = v.Cksum
+				break
+			}
+		}
+	}
+
+	if err := serveViaUpstream(w, r, actual, name, version, wantCksum); err != nil {
+		log.Printf("upstream fetch failed for %s: %v", subpath, err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+	}
+}
+
+// configJSON serves the registry config that `cargo` fetches first to learn
+// where to download crates from and where its API lives.
+func configJSON(req *restful.Request, resp *restful.Response) {
+	host := req.Request.Host
+	resp.WriteAsJson(map[string]string{
+		"dl":  fmt.Sprintf("http://%s/api/v1/crates/{crate}/{version}/download", host),
+		"api": fmt.Sprintf("http://%s", host),
+	})
+}
+
+// indexFor1or2 serves the index file for 1- and 2-character crate names,
+// which Cargo stores flat under /1 and /2 respectively.
+func indexFor1or2(req *restful.Request, resp *restful.Response) {
+	writeIndex(req, resp, req.PathParameter("name"))
 }
 
-func staticFromQueryParam(req *restful.Request, resp *restful.Response) {
-	http.ServeFile(
-		resp.ResponseWriter,
-		req.Request,
-		path.Join(rootdir, req.QueryParameter("resource")))
-}
\ No newline at end of file
+// indexFor3 serves the index file for 3-character crate names, sharded one
+// level deep by their first character.
+func indexFor3(req *restful.Request, resp *restful.Response) {
+	writeIndex(req, resp, req.PathParameter("name"))
+}
+
+// indexFor4plus serves the index file for crate names of 4 characters or
+// more, sharded two levels deep by their first four characters.
+func indexFor4plus(req *restful.Request, resp *restful.Response) {
+	writeIndex(req, resp, req.PathParameter("name"))
+}
+
+// writeIndex looks up name's raw index file and writes it to resp as
+// newline-delimited JSON, the format Cargo's sparse protocol expects. It
+// honors conditional GETs via If-None-Match/If-Modified-Since, short-
+// circuiting to 304 before paying to write the body again, since Cargo
+// issues a conditional GET against the sparse index on almost every resolve.
+func writeIndex(req *restful.Request, resp *restful.Response, name string) {
+	data, modTime, err := index.ReadRaw(strings.ToLower(name))
+	if err != nil {
+		resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if data == nil {
+		resp.WriteErrorString(http.StatusNotFound, "crate not found")
+		return
+	}
+
+	etag := indexETag(data)
+	resp.AddHeader("ETag", etag)
+	resp.AddHeader("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	resp.AddHeader("Cache-Control", "public, max-age=60")
+
+	if notModified(req.Request, etag, modTime) {
+		resp.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	resp.AddHeader("Content-Type", "application/json")
+	resp.Write(data)
+}
+
+// indexETag derives a strong ETag from the index file's content, so it
+// changes exactly when the body Cargo would receive changes.
+func indexETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// notModified reports whether r's conditional headers indicate the client
+// already has the current version: If-None-Match takes precedence over
+// If-Modified-Since, matching RFC 7232.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return inm == etag
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		if t, err := http.ParseTime(ims); err == nil {
+			return !modTime.Truncate(time.Second).After(t)
+		}
+	}
+	return false
+}
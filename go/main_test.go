@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotModifiedIfNoneMatch(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/1/a", nil)
+	r.Header.Set("If-None-Match", `"abc"`)
+
+	if !notModified(r, `"abc"`, time.Now()) {
+		t.Fatal("matching If-None-Match should short-circuit to not-modified")
+	}
+	if notModified(r, `"different"`, time.Now()) {
+		t.Fatal("mismatched If-None-Match should not short-circuit")
+	}
+}
+
+func TestNotModifiedIfModifiedSince(t *testing.T) {
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r := httptest.NewRequest(http.MethodGet, "/1/a", nil)
+	r.Header.Set("If-Modified-Since", modTime.Format(http.TimeFormat))
+	if !notModified(r, `"etag"`, modTime) {
+		t.Fatal("unchanged mtime should be reported as not modified")
+	}
+
+	r2 := httptest.NewRequest(http.MethodGet, "/1/a", nil)
+	r2.Header.Set("If-Modified-Since", modTime.Format(http.TimeFormat))
+	if notModified(r2, `"etag"`, modTime.Add(time.Hour)) {
+		t.Fatal("newer mtime should not be reported as not modified")
+	}
+}
+
+func TestIndexETagStableForSameContent(t *testing.T) {
+	a := indexETag([]byte(`{"name":"foo"}`))
+	b := indexETag([]byte(`{"name":"foo"}`))
+	c := indexETag([]byte(`{"name":"bar"}`))
+	if a != b {
+		t.Fatalf("etag should be stable for identical content: %s != %s", a, b)
+	}
+	if a == c {
+		t.Fatal("etag should differ for different content")
+	}
+}
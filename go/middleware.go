@@ -0,0 +1,180 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/emicklei/go-restful"
+)
+
+// requestIDKey is the context key requestID filters store the request ID
+// under, so downstream handlers and the access log can read it back.
+type requestIDKey struct{}
+
+// Config controls which filters installFilters wires into a container.
+// Embedders that want e.g. logging but not gzip can flip individual fields
+// off.
+type Config struct {
+	RequestID bool
+	AccessLog bool
+	Gzip      bool
+	Recover   bool
+}
+
+// DefaultConfig enables every filter.
+func DefaultConfig() Config {
+	return Config{RequestID: true, AccessLog: true, Gzip: true, Recover: true}
+}
+
+// installFilters wires the filters enabled by cfg into container, in the
+// order request ID, access log, gzip, recover. Filters wrap everything
+// installed after them, so recover must be innermost (last): it has to run
+// closer to the handler than accessLogFilter's post-chain log line and
+// gzipFilter's response wrapping, or a panic unwinds straight past both
+// before recoverFilter ever turns it into a logged 500.
+func installFilters(container *restful.Container, cfg Config) {
+	if cfg.RequestID {
+		container.Filter(requestIDFilter)
+	}
+	if cfg.AccessLog {
+		container.Filter(accessLogFilter)
+	}
+	if cfg.Gzip {
+		container.Filter(gzipFilter)
+	}
+	if cfg.Recover {
+		container.Filter(recoverFilter)
+	}
+}
+
+// requestIDFilter reads X-Request-ID from the incoming request or generates
+// one, threads it onto the request context, and echoes it back on the
+// response.
+func requestIDFilter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	id := req.Request.Header.Get("X-Request-ID")
+	if id == "" {
+		id = newRequestID()
+	}
+	resp.AddHeader("X-Request-ID", id)
+	req.Request = req.Request.WithContext(context.WithValue(req.Request.Context(), requestIDKey{}, id))
+	chain.ProcessFilter(req, resp)
+}
+
+// newRequestID returns a short random hex ID. It isn't a real ULID (no
+// external dependency for something this small) and carries no timestamp, so
+// IDs do not sort by creation time; it is a unique, loggable token per
+// request.
+func newRequestID() string {
+	var b [10]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// statusWriter wraps http.ResponseWriter to capture the status code and
+// byte count for accessLogFilter.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// accessLogFilter logs one structured JSON line per request: method, path,
+// status, bytes served, duration, remote IP (honoring X-Forwarded-For), and
+// the request ID set by requestIDFilter.
+func accessLogFilter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: resp.ResponseWriter}
+	resp.ResponseWriter = sw
+
+	chain.ProcessFilter(req, resp)
+
+	slog.Info("request",
+		"method", req.Request.Method,
+		"path", req.Request.URL.Path,
+		"status", sw.status,
+		"bytes", sw.bytes,
+		"duration_ms", time.Since(start).Milliseconds(),
+		"remote_ip", remoteIP(req.Request),
+		"request_id", requestIDFrom(req.Request.Context()),
+	)
+}
+
+// remoteIP returns the left-most address in X-Forwarded-For when present,
+// falling back to the connection's RemoteAddr.
+func remoteIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		return strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	return r.RemoteAddr
+}
+
+// gzipWriter wraps http.ResponseWriter so gzip.Writer.Write satisfies it.
+type gzipWriter struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	return w.gz.Write(b)
+}
+
+// gzipFilter compresses the response body when the client accepts gzip,
+// skipping .crate downloads which are already compressed binary blobs.
+func gzipFilter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	if strings.HasSuffix(req.Request.URL.Path, ".crate") ||
+		strings.HasSuffix(req.Request.URL.Path, "/download") ||
+		!strings.Contains(req.Request.Header.Get("Accept-Encoding"), "gzip") {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+
+	resp.AddHeader("Content-Encoding", "gzip")
+	resp.Header().Del("Content-Length")
+	gz := gzip.NewWriter(resp.ResponseWriter)
+	defer gz.Close()
+	resp.ResponseWriter = &gzipWriter{ResponseWriter: resp.ResponseWriter, gz: gz}
+
+	chain.ProcessFilter(req, resp)
+}
+
+// recoverFilter turns a panicking handler into a 500 response instead of a
+// crashed server, logging the stack alongside the request ID.
+func recoverFilter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("panic",
+				"error", r,
+				"request_id", requestIDFrom(req.Request.Context()),
+				"stack", string(debug.Stack()),
+			)
+			resp.WriteErrorString(http.StatusInternalServerError, "internal error")
+		}
+	}()
+	chain.ProcessFilter(req, resp)
+}
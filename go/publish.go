@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/emicklei/go-restful"
+
+	"github.com/m0ssc0de/cargo-fetcher/sparse"
+)
+
+var tokenFile = flag.String("token-file", "", "path to a file of newline-separated bearer tokens allowed to publish; publishing is disabled if empty")
+
+// Upper bounds on the two length-prefixed blocks of a publish request. A
+// token only authorizes publishing, not an unbounded allocation, so an
+// oversized declared length is rejected before make() ever sees it.
+const (
+	maxMetadataSize = 1 << 20   // 1 MiB is generous for Cargo.toml-derived metadata
+	maxCrateSize    = 256 << 20 // 256 MiB, well above any real crate tarball
+)
+
+// crateLocks serializes writes (publish, yank, unyank) to a single crate's
+// index file, since AppendVersion/SetYanked are each read-modify-write.
+var crateLocks sync.Map // map[string]*sync.Mutex
+
+func lockFor(name string) *sync.Mutex {
+	v, _ := crateLocks.LoadOrStore(strings.ToLower(name), &sync.Mutex{})
+	return v.(*sync.Mutex)
+}
+
+// publishMeta is the JSON metadata that precedes the crate tarball in the
+// publish request body. Only the fields the index needs are kept; the rest
+// of what `cargo publish` sends (readme, repository, ...) is accepted but
+// ignored.
+type publishMeta struct {
+	Name        string              `json:"name"`
+	Vers        string              `json:"vers"`
+	Deps        []sparse.Dependency `json:"deps"`
+	Features    map[string][]string `json:"features"`
+	RustVersion string              `json:"rust_version,omitempty"`
+}
+
+// publishWarnings is the response body Cargo expects on a successful
+// publish.
+type publishWarnings struct {
+	Warnings struct {
+		InvalidCategories []string `json:"invalid_categories"`
+		InvalidBadges     []string `json:"invalid_badges"`
+		Other             []string `json:"other"`
+	} `json:"warnings"`
+}
+
+// requireToken validates the Authorization header against -token-file. It
+// writes the response and returns false if the request should stop here.
+func requireToken(req *restful.Request, resp *restful.Response) bool {
+	if *tokenFile == "" {
+		resp.WriteErrorString(http.StatusForbidden, "publishing is disabled")
+		return false
+	}
+	allowed, err := os.ReadFile(*tokenFile)
+	if err != nil {
+		resp.WriteErrorString(http.StatusInternalServerError, "reading token file: "+err.Error())
+		return false
+	}
+
+	got := []byte(strings.TrimPrefix(req.Request.Header.Get("Authorization"), "Bearer "))
+	for _, want := range strings.Split(string(allowed), "\n") {
+		want = strings.TrimSpace(want)
+		if want != "" && subtle.ConstantTimeCompare([]byte(want), got) == 1 {
+			return true
+		}
+	}
+	resp.WriteErrorString(http.StatusUnauthorized, "invalid or missing token")
+	return false
+}
+
+// publishCrate implements PUT /api/v1/crates/new: Cargo's binary framing of
+// a JSON metadata block followed by the .crate tarball, each prefixed with
+// its own 4-byte little-endian length.
+func publishCrate(req *restful.Request, resp *restful.Response) {
+	if !requireToken(req, resp) {
+		return
+	}
+
+	body := req.Request.Body
+	defer body.Close()
+
+	meta, err := readLenPrefixedJSON(body, maxMetadataSize)
+	if err != nil {
+		resp.WriteErrorString(http.StatusBadRequest, "reading metadata: "+err.Error())
+		return
+	}
+	crateBytes, err := readLenPrefixedBytes(body, maxCrateSize)
+	if err != nil {
+		resp.WriteErrorString(http.StatusBadRequest, "reading crate file: "+err.Error())
+		return
+	}
+
+	if err := sparse.ValidateName(meta.Name); err != nil {
+		resp.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := sparse.ValidateVersion(meta.Vers); err != nil {
+		resp.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sum := sha256.Sum256(crateBytes)
+	cksum := hex.EncodeToString(sum[:])
+
+	mu := lockFor(meta.Name)
+	mu.Lock()
+	defer mu.Unlock()
+
+	dst := filepath.Join(rootdir, meta.Name, fmt.Sprintf("%s-%s.crate", meta.Name, meta.Vers))
+	if err := writeFileAtomic(dst, crateBytes); err != nil {
+		resp.WriteErrorString(http.StatusInternalServerError, "storing crate: "+err.Error())
+		return
+	}
+
+	if err := index.AppendVersion(sparse.VersionMeta{
+		Name:        meta.Name,
+		Vers:        meta.Vers,
+		Deps:        meta.Deps,
+		Cksum:       cksum,
+		Features:    meta.Features,
+		Yanked:      false,
+		RustVersion: meta.RustVersion,
+	}); err != nil {
+		resp.WriteErrorString(http.StatusInternalServerError, "updating index: "+err.Error())
+		return
+	}
+
+	resp.WriteAsJson(publishWarnings{})
+}
+
+// yankCrate implements DELETE /api/v1/crates/{name}/{version}/yank.
+func yankCrate(req *restful.Request, resp *restful.Response) {
+	setYanked(req, resp, true)
+}
+
+// unyankCrate implements PUT /api/v1/crates/{name}/{version}/unyank.
+func unyankCrate(req *restful.Request, resp *restful.Response) {
+	setYanked(req, resp, false)
+}
+
+func setYanked(req *restful.Request, resp *restful.Response, yanked bool) {
+	if !requireToken(req, resp) {
+		return
+	}
+
+	name := req.PathParameter("name")
+	version := req.PathParameter("version")
+	if err := sparse.ValidateName(name); err != nil {
+		resp.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := sparse.ValidateVersion(version); err != nil {
+		resp.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	mu := lockFor(name)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := index.SetYanked(name, version, yanked); err != nil {
+		resp.WriteErrorString(http.StatusNotFound, err.Error())
+		return
+	}
+	resp.WriteAsJson(map[string]bool{"ok": true})
+}
+
+// readLenPrefixedJSON reads a 4-byte little-endian length followed by that
+// many bytes of JSON, as used for the metadata block of a publish request.
+func readLenPrefixedJSON(r io.Reader, maxLen uint32) (publishMeta, error) {
+	var meta publishMeta
+	raw, err := readLenPrefixedBytes(r, maxLen)
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return meta, err
+	}
+	return meta, nil
+}
+
+// readLenPrefixedBytes reads a 4-byte little-endian length followed by that
+// many bytes, Cargo's framing for both the metadata and crate file blocks. It
+// rejects a declared length over maxLen before allocating, so a client can't
+// force a multi-gigabyte allocation by lying about the length.
+func readLenPrefixedBytes(r io.Reader, maxLen uint32) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > maxLen {
+		return nil, fmt.Errorf("declared length %d exceeds maximum of %d bytes", length, maxLen)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// writeFileAtomic writes data to dst via a temp file + rename, so concurrent
+// downloaders never see a partially written crate.
+func writeFileAtomic(dst string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp-"+filepath.Base(dst)+"-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, dst)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func lenPrefixed(b []byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(len(b)))
+	buf.Write(b)
+	return buf.Bytes()
+}
+
+func TestReadLenPrefixedBytesRejectsOversizedLength(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(1<<31)) // declares 2GiB, sends nothing
+
+	if _, err := readLenPrefixedBytes(&buf, maxCrateSize); err == nil {
+		t.Fatal("expected an error for a declared length over the cap")
+	}
+}
+
+func TestReadLenPrefixedBytesAcceptsWithinCap(t *testing.T) {
+	want := []byte("hello crate")
+	got, err := readLenPrefixedBytes(bytes.NewReader(lenPrefixed(want)), maxCrateSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
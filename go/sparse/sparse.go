@@ -0,0 +1,233 @@
+// Package sparse implements the on-disk layout and read/write access for
+// Cargo's sparse HTTP registry index, as described at
+// https://doc.rust-lang.org/cargo/reference/registry-index.html#sparse-registries.
+package sparse
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// crateNameRe and crateVersionRe bound what a crate name/version is allowed
+// to contain before it ever reaches the filesystem. Neither is a full check
+// of Cargo's real grammar (a leading digit or '-' in a name, or '_' in a
+// version, is accepted); rejecting anything outside
+// alphanumerics, '-', '_', and (for versions) '.'/'+' closes off path
+// traversal via "../" or an absolute path smuggled in through these fields.
+var (
+	crateNameRe    = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	crateVersionRe = regexp.MustCompile(`^[A-Za-z0-9_.+-]+$`)
+)
+
+// ValidateName reports an error if name contains anything other than
+// letters, digits, '-', or '_'.
+func ValidateName(name string) error {
+	if !crateNameRe.MatchString(name) {
+		return fmt.Errorf("sparse: invalid crate name %q", name)
+	}
+	return nil
+}
+
+// ValidateVersion reports an error if version contains anything other than
+// letters, digits, '.', '+', '-', or '_'.
+func ValidateVersion(version string) error {
+	if !crateVersionRe.MatchString(version) {
+		return fmt.Errorf("sparse: invalid crate version %q", version)
+	}
+	return nil
+}
+
+// VersionMeta is one newline-delimited-JSON line of a crate's index file,
+// describing a single published version.
+type VersionMeta struct {
+	Name        string              `json:"name"`
+	Vers        string              `json:"vers"`
+	Deps        []Dependency        `json:"deps"`
+	Cksum       string              `json:"cksum"`
+	Features    map[string][]string `json:"features"`
+	Yanked      bool                `json:"yanked"`
+	RustVersion string              `json:"rust_version,omitempty"`
+}
+
+// Dependency is a single entry of VersionMeta.Deps.
+type Dependency struct {
+	Name               string   `json:"name"`
+	Req                string   `json:"req"`
+	Features           []string `json:"features,omitempty"`
+	Optional           bool     `json:"optional"`
+	Default            bool     `json:"default_features"`
+	Target             string   `json:"target,omitempty"`
+	Kind               string   `json:"kind"`
+	Registry           string   `json:"registry,omitempty"`
+	ExplicitNameInToml string   `json:"explicit_name_in_toml,omitempty"`
+}
+
+// IndexStore reads and appends to the sparse index tree rooted at Root.
+type IndexStore struct {
+	Root string
+}
+
+// NewIndexStore returns an IndexStore rooted at root. The directory is not
+// created until the first write.
+func NewIndexStore(root string) *IndexStore {
+	return &IndexStore{Root: root}
+}
+
+// Path returns the on-disk path of the index file for crate name under root,
+// following Cargo's directory sharding rules: 1 and 2 character names are
+// stored flat, 3 character names get one sharding level, and everything else
+// is sharded by its first four characters. It rejects any name that isn't a
+// legal crate name, since name is otherwise spliced straight into a
+// filesystem path.
+func Path(root, name string) (string, error) {
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	lower := strings.ToLower(name)
+	switch len(lower) {
+	case 1:
+		return filepath.Join(root, "1", lower), nil
+	case 2:
+		return filepath.Join(root, "2", lower), nil
+	case 3:
+		return filepath.Join(root, "3", lower[:1], lower), nil
+	default:
+		return filepath.Join(root, lower[0:2], lower[2:4], lower), nil
+	}
+}
+
+// Read returns every published version of name, in the order they were
+// appended. It returns a nil slice (no error) if the crate has no index file
+// yet.
+func (s *IndexStore) Read(name string) ([]VersionMeta, error) {
+	p, err := Path(s.Root, name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var versions []VersionMeta
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var v VersionMeta
+		if err := json.Unmarshal(line, &v); err != nil {
+			return nil, fmt.Errorf("sparse: decoding %s: %w", name, err)
+		}
+		versions = append(versions, v)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// ReadRaw returns the raw bytes of name's index file along with its
+// modification time, so an HTTP handler can serve them directly and derive
+// caching headers (ETag, Last-Modified) without re-encoding the decoded
+// versions. It returns a nil data slice (no error) if the crate has no index
+// file yet.
+func (s *IndexStore) ReadRaw(name string) (data []byte, modTime time.Time, err error) {
+	p, err := Path(s.Root, name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err = os.ReadFile(p)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, info.ModTime(), nil
+}
+
+// AppendVersion adds meta as a new line to name's index file, creating the
+// file and its parent directories if necessary. It writes to a temp file and
+// renames it into place so that concurrent readers never observe a partial
+// file.
+func (s *IndexStore) AppendVersion(meta VersionMeta) error {
+	if err := ValidateVersion(meta.Vers); err != nil {
+		return err
+	}
+	existing, err := s.Read(meta.Name)
+	if err != nil {
+		return err
+	}
+	existing = append(existing, meta)
+	return s.writeAll(meta.Name, existing)
+}
+
+// SetYanked flips the yanked flag of version in name's index and rewrites it
+// atomically. It returns an error if the version is not found.
+func (s *IndexStore) SetYanked(name, version string, yanked bool) error {
+	if err := ValidateVersion(version); err != nil {
+		return err
+	}
+	versions, err := s.Read(name)
+	if err != nil {
+		return err
+	}
+	found := false
+	for i := range versions {
+		if versions[i].Vers == version {
+			versions[i].Yanked = yanked
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("sparse: %s@%s not found in index", name, version)
+	}
+	return s.writeAll(name, versions)
+}
+
+func (s *IndexStore) writeAll(name string, versions []VersionMeta) error {
+	dst, err := Path(s.Root, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp-"+filepath.Base(dst)+"-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op once renamed
+
+	enc := json.NewEncoder(tmp)
+	for _, v := range versions {
+		if err := enc.Encode(v); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, dst)
+}
@@ -0,0 +1,56 @@
+package sparse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPathRejectsTraversal(t *testing.T) {
+	for _, name := range []string{
+		"../../../../tmp/evil",
+		"../escape",
+		"a/b",
+		"a\\b",
+	} {
+		if _, err := Path("/tmp/fetcher/index", name); err == nil {
+			t.Errorf("Path(%q) should have been rejected as an invalid crate name", name)
+		}
+	}
+}
+
+func TestAppendVersionAndRead(t *testing.T) {
+	store := NewIndexStore(t.TempDir())
+
+	if err := store.AppendVersion(VersionMeta{Name: "foo", Vers: "1.0.0", Cksum: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AppendVersion(VersionMeta{Name: "foo", Vers: "1.1.0", Cksum: "def"}); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := store.Read("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Vers != "1.0.0" || versions[1].Vers != "1.1.0" {
+		t.Fatalf("unexpected versions: %+v", versions)
+	}
+
+	data, modTime, err := store.ReadRaw("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modTime.IsZero() {
+		t.Fatal("expected a non-zero mod time for an existing index file")
+	}
+	if !strings.Contains(string(data), `"1.1.0"`) {
+		t.Fatalf("raw index data missing expected version: %s", data)
+	}
+}
+
+func TestSetYankedRejectsInvalidVersion(t *testing.T) {
+	store := NewIndexStore(t.TempDir())
+	if err := store.SetYanked("foo", "../escape", true); err == nil {
+		t.Fatal("expected an error for a path-traversal version string")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	upstream  = flag.String("upstream", "https://static.crates.io/crates/{name}/{name}-{version}.crate", "upstream URL template used to fetch crates missing from rootdir")
+	offline   = flag.Bool("offline", false, "never fetch from upstream; 404 on cache misses")
+	cacheOnly = flag.Bool("cache-only", false, "alias for -offline, kept for readability at call sites")
+)
+
+// fetchGroup ensures that concurrent requests for the same missing crate
+// trigger a single upstream fetch; everyone else waits for it to land in the
+// cache rather than issuing their own request.
+var fetchGroup singleflight.Group
+
+// upstreamURL renders the -upstream template for the given crate name and
+// version.
+func upstreamURL(name, version string) string {
+	r := strings.NewReplacer("{name}", name, "{version}", version)
+	return r.Replace(*upstream)
+}
+
+// serveViaUpstream is called when dst is missing from rootdir. It fetches
+// name-version from the configured upstream into dst (see fetchToCache for
+// the integrity-checked part of that), then serves the now-cached dst to w.
+// Concurrent requests for the same dst share one upstream fetch via
+// fetchGroup: only a fully downloaded file (checksum-verified whenever
+// wantCksum is known) ever reaches a client, so a
+// disconnect or error on one caller's response writer can't corrupt what the
+// others receive, and a checksum mismatch never reaches any client as a
+// false 200.
+func serveViaUpstream(w http.ResponseWriter, req *http.Request, dst, name, version, wantCksum string) error {
+	if *offline || *cacheOnly {
+		return fmt.Errorf("upstream: %s-%s not cached and offline mode is enabled", name, version)
+	}
+
+	_, err, _ := fetchGroup.Do(dst, func() (interface{}, error) {
+		return nil, fetchToCache(dst, name, version, wantCksum)
+	})
+	if err != nil {
+		return err
+	}
+	http.ServeFile(w, req, dst)
+	return nil
+}
+
+// fetchToCache downloads name-version from the configured upstream to a temp
+// file, verifies it against wantCksum (skipped when empty), and only then
+// renames it into dst. Nothing is written to any client response writer
+// here; when wantCksum is set, it is verified before a single byte can be
+// served.
+// A failed or mismatched download deletes the temp file and never touches
+// dst, so it can't poison the cache.
+func fetchToCache(dst, name, version, wantCksum string) error {
+	url := upstreamURL(name, version)
+	resp, err := http.Get(url)
+	if err != nil {
+		return fmt.Errorf("upstream: fetching %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("upstream: fetching %s: status %s", url, resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp-"+filepath.Base(dst)+"-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	renamed := false
+	defer func() {
+		tmp.Close()
+		if !renamed {
+			os.Remove(tmpName)
+		}
+	}()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), resp.Body); err != nil {
+		return fmt.Errorf("upstream: downloading %s: %w", url, err)
+	}
+
+	if wantCksum != "" {
+		if got := hex.EncodeToString(h.Sum(nil)); got != wantCksum {
+			return fmt.Errorf("upstream: checksum mismatch for %s: got %s, want %s", url, got, wantCksum)
+		}
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, dst); err != nil {
+		return err
+	}
+	renamed = true
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func withUpstream(t *testing.T, body string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	prev := *upstream
+	*upstream = srv.URL + "/{name}/{name}-{version}.crate"
+	t.Cleanup(func() { *upstream = prev })
+	return srv.URL
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// A checksum mismatch must not rename the download into dst, and must leave
+// no temp file behind.
+func TestFetchToCacheChecksumMismatchDoesNotPoisonCache(t *testing.T) {
+	withUpstream(t, "tampered-bytes")
+	dst := filepath.Join(t.TempDir(), "foo-1.0.0.crate")
+
+	err := fetchToCache(dst, "foo", "1.0.0", sha256Hex("expected-bytes"))
+	if err == nil {
+		t.Fatal("expected a checksum mismatch error, got nil")
+	}
+	if _, statErr := os.Stat(dst); !os.IsNotExist(statErr) {
+		t.Fatalf("dst should not exist after a checksum mismatch, stat err = %v", statErr)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(dst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		t.Fatalf("leftover file after failed download: %s", e.Name())
+	}
+}
+
+// A checksum mismatch must never reach the client: serveViaUpstream should
+// return an error without writing anything to the response.
+func TestServeViaUpstreamChecksumMismatchDoesNotLeakBytes(t *testing.T) {
+	withUpstream(t, "tampered-bytes")
+	dst := filepath.Join(t.TempDir(), "foo-1.0.0.crate")
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/crates/foo/1.0.0/download", nil)
+
+	err := serveViaUpstream(rec, req, dst, "foo", "1.0.0", sha256Hex("expected-bytes"))
+	if err == nil {
+		t.Fatal("expected a checksum mismatch error, got nil")
+	}
+	if rec.Body.Len() != 0 {
+		t.Fatalf("client should receive nothing on checksum mismatch, got %q", rec.Body.String())
+	}
+}
+
+// A verified download is cached and served to the caller.
+func TestServeViaUpstreamSuccess(t *testing.T) {
+	const body = "real-crate-bytes"
+	withUpstream(t, body)
+	dst := filepath.Join(t.TempDir(), "foo-1.0.0.crate")
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/crates/foo/1.0.0/download", nil)
+
+	if err := serveViaUpstream(rec, req, dst, "foo", "1.0.0", sha256Hex(body)); err != nil {
+		t.Fatalf("serveViaUpstream: %v", err)
+	}
+	if rec.Body.String() != body {
+		t.Fatalf("client got %q, want %q", rec.Body.String(), body)
+	}
+	if cached, err := os.ReadFile(dst); err != nil || string(cached) != body {
+		t.Fatalf("dst not cached correctly: data=%q err=%v", cached, err)
+	}
+}